@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// completionShell is a shell bcrm can generate a completion script for.
+type completionShell string
+
+const (
+	shellBash completionShell = "bash"
+	shellZsh  completionShell = "zsh"
+	shellFish completionShell = "fish"
+)
+
+// imageTypeSuffixes are the --source-image/--destination-image type tags
+// completed after the first ':' in <path>:<type>.
+var imageTypeSuffixes = []string{"raw", "vdi", "qcow2", "vmdk", "vhdx"}
+
+// runCompletionSubcommand handles "bcrm completion <shell>", printing a
+// completion script to stdout. It returns false if argv isn't a completion
+// invocation, so the caller can fall through to the regular CLI.
+func runCompletionSubcommand(argv []string) bool {
+	if len(argv) < 2 || argv[0] != "completion" {
+		return false
+	}
+
+	script, err := generateCompletionScript(completionShell(argv[1]), getopt.CommandLine)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(script)
+	return true
+}
+
+// longOptionNames returns every "--name" registered on the command line.
+func longOptionNames(set *getopt.Set) []string {
+	var names []string
+	set.VisitAll(func(o getopt.Option) {
+		if n := o.LongName(); n != "" {
+			names = append(names, "--"+n)
+		}
+	})
+	return names
+}
+
+func generateCompletionScript(shell completionShell, set *getopt.Set) (string, error) {
+	names := longOptionNames(set)
+	switch shell {
+	case shellBash:
+		return bashCompletionScript(names), nil
+	case shellZsh:
+		return zshCompletionScript(names), nil
+	case shellFish:
+		return fishCompletionScript(names), nil
+	default:
+		return "", errors.New(trf("unsupported --completion shell %q, must be one of bash|zsh|fish", shell))
+	}
+}
+
+func bashCompletionScript(names []string) string {
+	return `_bcrm_complete() {
+    local cur
+    cur="${COMP_WORDS[COMP_CWORD]}"
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "` + strings.Join(names, " ") + `" -- "$cur"))
+        return
+    fi
+
+    local dynamic
+    dynamic=$(BCRM_COMPLETE=1 "${COMP_WORDS[0]}" "${COMP_WORDS[@]:1:COMP_CWORD}")
+    COMPREPLY=($(compgen -W "$dynamic" -- "$cur"))
+}
+complete -F _bcrm_complete bcrm
+`
+}
+
+func zshCompletionScript(names []string) string {
+	return `#compdef bcrm
+_bcrm() {
+    local cur
+    cur="${words[CURRENT]}"
+
+    if [[ "$cur" == -* ]]; then
+        compadd -- ` + strings.Join(names, " ") + `
+        return
+    fi
+
+    local -a dynamic
+    dynamic=(${(f)"$(BCRM_COMPLETE=1 bcrm "${words[2,CURRENT]}")"})
+    compadd -a dynamic
+}
+compdef _bcrm bcrm
+`
+}
+
+func fishCompletionScript(names []string) string {
+	var b strings.Builder
+	for _, n := range names {
+		fmt.Fprintf(&b, "complete -c bcrm -l %s\n", strings.TrimPrefix(n, "--"))
+	}
+	b.WriteString(`complete -c bcrm -f -a '(BCRM_COMPLETE=1 bcrm (commandline -opc) (commandline -ct))'
+`)
+	return b.String()
+}
+
+// runDynamicCompletion implements the BCRM_COMPLETE=1 side channel the
+// generated shell scripts call into: words is every word typed on the
+// command line so far, up to and including the one currently being
+// completed. It prints one completion candidate per line.
+func runDynamicCompletion(words []string) {
+	if len(words) < 2 {
+		return
+	}
+	cur := words[len(words)-1]
+	prev := words[len(words)-2]
+
+	switch prev {
+	case "-s", "--source", "-d", "--destination":
+		for _, p := range matchingPaths(cur) {
+			fmt.Println(p)
+		}
+	case "--source-image", "--destination-image":
+		completeImageArg(cur)
+	case "--disable-mount":
+		for _, m := range fstabMountpoints(optionArg(words, "-d", "--destination")) {
+			if strings.HasPrefix(m, cur) {
+				fmt.Println(m)
+			}
+		}
+	case "--to-lvm":
+		for _, c := range sourceLvmCandidates(optionArg(words, "-s", "--source")) {
+			if strings.HasPrefix(c, cur) {
+				fmt.Println(c)
+			}
+		}
+	}
+}
+
+// optionArg scans words for the last occurrence of short or long ("-d" /
+// "--destination") and returns the value that follows it, or the value of
+// a "--destination=..." form. It returns "" if the option wasn't found.
+func optionArg(words []string, short, long string) string {
+	value := ""
+	longEquals := long + "="
+	for i, w := range words {
+		switch {
+		case (w == short || w == long) && i+1 < len(words):
+			value = words[i+1]
+		case strings.HasPrefix(w, longEquals):
+			value = strings.TrimPrefix(w, longEquals)
+		}
+	}
+	return value
+}
+
+// fstabMountpoints reads <dest>/etc/fstab and returns every mountpoint it
+// declares, for completing --disable-mount.
+func fstabMountpoints(dest string) []string {
+	if dest == "" {
+		return nil
+	}
+	var mounts []string
+	for _, fields := range fstabFields(dest) {
+		mounts = append(mounts, fields[1])
+	}
+	return mounts
+}
+
+// sourceLvmCandidates reads <source>/etc/fstab and returns one
+// "<device>:<lv-name>" candidate per entry with a real block device and a
+// mountpoint, matching the "/dev/sda1:boot" syntax --to-lvm expects.
+func sourceLvmCandidates(source string) []string {
+	if source == "" {
+		return nil
+	}
+	var candidates []string
+	for _, fields := range fstabFields(source) {
+		device, mount := fields[0], fields[1]
+		if !strings.HasPrefix(device, "/dev/") {
+			continue
+		}
+		name := strings.Trim(mount, "/")
+		if name == "" {
+			name = "root"
+		}
+		name = strings.ReplaceAll(name, "/", "-")
+		candidates = append(candidates, device+":"+name)
+	}
+	return candidates
+}
+
+// fstabFields parses <root>/etc/fstab, skipping comments and blank lines,
+// and returns the whitespace-separated fields of every remaining line.
+func fstabFields(root string) [][]string {
+	data, err := os.ReadFile(filepath.Join(root, "etc", "fstab"))
+	if err != nil {
+		return nil
+	}
+
+	var entries [][]string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		entries = append(entries, fields)
+	}
+	return entries
+}
+
+// matchingPaths expands cur as a path glob, used to complete devices and
+// directories for -s/-d/--disable-mount.
+func matchingPaths(cur string) []string {
+	matches, _ := filepath.Glob(cur + "*")
+	return matches
+}
+
+// completeImageArg completes the <type> portion of <path>:<type>[:<size>],
+// once the path and its trailing ':' have already been typed.
+func completeImageArg(cur string) {
+	if !strings.Contains(cur, ":") {
+		for _, p := range matchingPaths(cur) {
+			fmt.Println(p)
+		}
+		return
+	}
+	prefix := cur[:strings.LastIndex(cur, ":")+1]
+	for _, t := range imageTypeSuffixes {
+		fmt.Println(prefix + t)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOptionArg(t *testing.T) {
+	cases := []struct {
+		words       []string
+		short, long string
+		want        string
+	}{
+		{[]string{"bcrm", "-d", "/mnt/dest"}, "-d", "--destination", "/mnt/dest"},
+		{[]string{"bcrm", "--destination", "/mnt/dest"}, "-d", "--destination", "/mnt/dest"},
+		{[]string{"bcrm", "--destination=/mnt/dest"}, "-d", "--destination", "/mnt/dest"},
+		{[]string{"bcrm", "-d", "/mnt/a", "-d", "/mnt/b"}, "-d", "--destination", "/mnt/b"},
+		{[]string{"bcrm", "-s", "/dev/sda"}, "-d", "--destination", ""},
+	}
+
+	for _, c := range cases {
+		got := optionArg(c.words, c.short, c.long)
+		if got != c.want {
+			t.Errorf("optionArg(%v, %q, %q) = %q, want %q", c.words, c.short, c.long, got, c.want)
+		}
+	}
+}
+
+func TestFstabMountpointsAndLvmCandidates(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	fstab := "# comment\n\nUUID=abc / ext4 defaults 0 1\n/dev/sda1 /boot ext4 defaults 0 2\n"
+	if err := os.WriteFile(filepath.Join(root, "etc", "fstab"), []byte(fstab), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	gotMounts := fstabMountpoints(root)
+	wantMounts := []string{"/", "/boot"}
+	if !reflect.DeepEqual(gotMounts, wantMounts) {
+		t.Errorf("fstabMountpoints = %v, want %v", gotMounts, wantMounts)
+	}
+
+	gotCandidates := sourceLvmCandidates(root)
+	wantCandidates := []string{"/dev/sda1:boot"}
+	if !reflect.DeepEqual(gotCandidates, wantCandidates) {
+		t.Errorf("sourceLvmCandidates = %v, want %v", gotCandidates, wantCandidates)
+	}
+}
+
+func TestFstabMountpointsMissingFstab(t *testing.T) {
+	if got := fstabMountpoints(t.TempDir()); got != nil {
+		t.Errorf("fstabMountpoints = %v, want nil", got)
+	}
+}
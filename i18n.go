@@ -0,0 +1,16 @@
+package main
+
+import "github.com/Jeansen/bcrm_go/internal/i18n"
+
+// tr translates msgid into the user's locale. If no catalog is loaded, or
+// the catalog has no translation for msgid, the English source text is
+// returned unchanged.
+func tr(msgid string) string {
+	return i18n.Tr(msgid)
+}
+
+// trf translates the format string msgid and applies fmt.Sprintf with args,
+// for messages that interpolate a path or value.
+func trf(msgid string, args ...interface{}) string {
+	return i18n.Trf(msgid, args...)
+}
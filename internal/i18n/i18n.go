@@ -0,0 +1,40 @@
+// Package i18n wraps gettext-go so both the CLI (package main) and the
+// internal/image library can translate user-facing messages through the
+// same "bcrm" catalog, instead of each owning its own gettext bootstrap.
+package i18n
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chai2010/gettext-go"
+)
+
+// domain is the catalog name bcrm looks up, i.e. bcrm.mo.
+const domain = "bcrm"
+
+// localeDir follows the usual FHS layout for gettext catalogs:
+// <localeDir>/<lang>/LC_MESSAGES/bcrm.mo
+const localeDir = "/usr/share/locale"
+
+func init() {
+	lang := os.Getenv("LC_MESSAGES")
+	if lang == "" {
+		lang = os.Getenv("LANG")
+	}
+	gettext.SetLanguage(lang)
+	gettext.BindLocale(gettext.New(domain, localeDir))
+}
+
+// Tr translates msgid into the user's locale. If no catalog is loaded, or
+// the catalog has no translation for msgid, the English source text is
+// returned unchanged.
+func Tr(msgid string) string {
+	return gettext.Gettext(msgid)
+}
+
+// Trf translates the format string msgid and applies fmt.Sprintf with args,
+// for messages that interpolate a path or value.
+func Trf(msgid string, args ...interface{}) string {
+	return fmt.Sprintf(Tr(msgid), args...)
+}
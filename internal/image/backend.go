@@ -0,0 +1,72 @@
+package image
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/Jeansen/bcrm_go/internal/i18n"
+)
+
+// Backend owns attaching a virtual image's container format to a block
+// device so the rest of bcrm always sees a plain device path, independent
+// of whether the image is a raw file, a qcow2/vmdk/vhdx/vdi disk, or
+// something only libguestfs can make sense of.
+type Backend interface {
+	// Open prepares the backend to work with the image at path. It does
+	// not attach anything yet.
+	Open(path string) error
+
+	// Attach makes the image available and returns a path to it: a real
+	// block device, e.g. "/dev/loop0" or "/dev/nbd0", for the loop and
+	// qemu-nbd backends. The libguestfs backend has no such device to give
+	// and returns a guestmount FUSE mountpoint instead; callers that need
+	// device-level (raw sector) access should check for familyDetector
+	// rather than assume the result is always a device.
+	Attach() (device string, err error)
+
+	// Detach releases the device and any other resources Attach acquired.
+	Detach() error
+
+	// Resize grows or shrinks the underlying virtual image to sizeMB.
+	Resize(sizeMB int) error
+
+	// VirtualSize returns the declared virtual size of the image in
+	// megabytes.
+	VirtualSize() (int, error)
+}
+
+// NewBackend picks the best backend available for the given image type.
+//
+// raw images are always handled through a loop device. Every other format
+// is handled through qemu-nbd unless the host cannot load the nbd or kvm
+// kernel modules (e.g. inside an unprivileged container or CI), in which
+// case bcrm falls back to a libguestfs-backed backend.
+func NewBackend(t Type) (Backend, error) {
+	if !validTypes[t] {
+		return nil, errors.New(i18n.Trf("Unsupported image type: %s", string(t)))
+	}
+
+	if t == Raw {
+		return &rawBackend{}, nil
+	}
+
+	if hasNBDSupport() {
+		return &qemuNBDBackend{imgType: t}, nil
+	}
+
+	return &libguestfsBackend{imgType: t}, nil
+}
+
+// hasNBDSupport reports whether the host can attach qemu-nbd devices, i.e.
+// the nbd kernel module can be loaded (or is already loaded) and qemu-nbd
+// is installed.
+func hasNBDSupport() bool {
+	if _, err := exec.LookPath("qemu-nbd"); err != nil {
+		return false
+	}
+	if _, err := os.Stat("/sys/module/nbd"); err == nil {
+		return true
+	}
+	return exec.Command("modprobe", "nbd").Run() == nil
+}
@@ -0,0 +1,17 @@
+package image
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/Jeansen/bcrm_go/internal/i18n"
+)
+
+// Convert converts src to dstType and writes the result to dstPath using
+// qemu-img, e.g. for --convert-image-to raw<->qcow2 conversions.
+func Convert(src Image, dstPath string, dstType Type) error {
+	if !validTypes[dstType] {
+		return errors.New(i18n.Trf("Unsupported image type: %s", string(dstType)))
+	}
+	return exec.Command("qemu-img", "convert", "-O", string(dstType), src.Path, dstPath).Run()
+}
@@ -0,0 +1,167 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+// ntfsBootMagic is the OEM ID found at offset 3 of an NTFS boot sector.
+var ntfsBootMagic = []byte("NTFS    ")
+
+// ext superblocks carry this magic number at offset 1080.
+const extSuperblockMagicOffset = 1080
+
+var extSuperblockMagic = []byte{0x53, 0xef}
+
+// mbrPartitionTableOffset, mbrBootSignatureOffset and gptProtectiveType are
+// the well-known MBR layout offsets (see the "Master Boot Record" section
+// of any partitioning spec): a 4x16 byte partition table starting at 446,
+// and the 0x55AA boot signature at the last two bytes of the sector.
+const (
+	mbrPartitionTableOffset = 446
+	mbrPartitionEntrySize   = 16
+	mbrBootSignatureOffset  = 510
+	gptProtectiveType       = 0xee
+
+	mbrTypeNTFSOrExFAT = 0x07
+	mbrTypeHiddenNTFS  = 0x17
+	mbrTypeLinux       = 0x83
+	mbrTypeLinuxLVM    = 0x8e
+)
+
+// GPT partition type GUIDs, in their on-disk (mixed-endian) byte order.
+var (
+	gptMicrosoftBasicData = []byte{0xa2, 0xa0, 0xd0, 0xeb, 0xe5, 0xb9, 0x33, 0x44, 0x87, 0xc0, 0x68, 0xb6, 0xb7, 0x26, 0x99, 0xc7}
+	gptLinuxFilesystem    = []byte{0xaf, 0x3d, 0xc6, 0x0f, 0x83, 0x84, 0x72, 0x47, 0x8e, 0x79, 0x3d, 0x69, 0xd8, 0x47, 0x7d, 0xe4}
+)
+
+// detectFamilyAtPath sniffs the guest layout behind path, which may be a
+// raw disk image file or a device attached to one by a Backend. It handles
+// three layouts:
+//
+//   - an MBR-partitioned disk: the partition table is walked and any NTFS
+//     or Linux partition type decides the family;
+//   - a GPT-partitioned disk: the GPT partition entries are walked and
+//     their type GUIDs decide the family;
+//   - a bare filesystem with no partition table at all (e.g. an image that
+//     is just an NTFS or ext filesystem, with no MBR/GPT wrapping it).
+func detectFamilyAtPath(path string) (Family, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Unknown, err
+	}
+	defer f.Close()
+
+	sector0 := make([]byte, 512)
+	if _, err := f.ReadAt(sector0, 0); err != nil {
+		return Unknown, err
+	}
+
+	if isNTFSBootSector(sector0) {
+		return Windows, nil
+	}
+
+	if sector0[mbrBootSignatureOffset] != 0x55 || sector0[mbrBootSignatureOffset+1] != 0xaa {
+		// No MBR/GPT boot signature at all, so this can only be a bare
+		// filesystem with no partition table wrapping it. Only check for
+		// that here: the ext superblock's fixed offset 1080 lands inside a
+		// GPT disk's first partition entry (entries start at byte 1024,
+		// name field at +56), so checking it before we know there's no
+		// partition table risks misreading partition-name bytes as an ext
+		// magic number and skipping the GPT walk below entirely.
+		if isExtSuperblock(f) {
+			return Linux, nil
+		}
+		return Unknown, nil
+	}
+
+	if isGPTProtectiveMBR(sector0) {
+		return detectFamilyFromGPT(f)
+	}
+	return detectFamilyFromMBR(f, sector0)
+}
+
+func isNTFSBootSector(sector []byte) bool {
+	return len(sector) >= 11 && bytes.Equal(sector[3:11], ntfsBootMagic)
+}
+
+func isExtSuperblock(f *os.File) bool {
+	magic := make([]byte, 2)
+	_, err := f.ReadAt(magic, extSuperblockMagicOffset)
+	return err == nil && bytes.Equal(magic, extSuperblockMagic)
+}
+
+func isGPTProtectiveMBR(sector0 []byte) bool {
+	return sector0[mbrPartitionTableOffset+4] == gptProtectiveType
+}
+
+// detectFamilyFromMBR walks the 4 primary MBR partition entries. Partition
+// type 0x07/0x17 (NTFS/exFAT) is confirmed by reading that partition's own
+// boot sector; 0x83/0x8e (Linux native/LVM) marks the disk as Linux absent
+// a Windows partition taking precedence.
+func detectFamilyFromMBR(f *os.File, sector0 []byte) (Family, error) {
+	sawLinux := false
+
+	for i := 0; i < 4; i++ {
+		entry := sector0[mbrPartitionTableOffset+i*mbrPartitionEntrySize:]
+		ptype := entry[4]
+		if ptype == 0 {
+			continue
+		}
+		lba := binary.LittleEndian.Uint32(entry[8:12])
+
+		switch ptype {
+		case mbrTypeNTFSOrExFAT, mbrTypeHiddenNTFS:
+			boot := make([]byte, 512)
+			if _, err := f.ReadAt(boot, int64(lba)*512); err == nil && isNTFSBootSector(boot) {
+				return Windows, nil
+			}
+		case mbrTypeLinux, mbrTypeLinuxLVM:
+			sawLinux = true
+		}
+	}
+
+	if sawLinux {
+		return Linux, nil
+	}
+	return Unknown, nil
+}
+
+// detectFamilyFromGPT reads the GPT header at LBA1 and walks its partition
+// entry array, matching known partition type GUIDs.
+func detectFamilyFromGPT(f *os.File) (Family, error) {
+	header := make([]byte, 512)
+	if _, err := f.ReadAt(header, 512); err != nil {
+		return Unknown, err
+	}
+
+	entryLBA := binary.LittleEndian.Uint64(header[72:80])
+	numEntries := binary.LittleEndian.Uint32(header[80:84])
+	entrySize := binary.LittleEndian.Uint32(header[84:88])
+	if numEntries == 0 || entrySize == 0 {
+		return Unknown, nil
+	}
+
+	entries := make([]byte, int(numEntries)*int(entrySize))
+	if _, err := f.ReadAt(entries, int64(entryLBA)*512); err != nil {
+		return Unknown, err
+	}
+
+	sawLinux := false
+	for i := uint32(0); i < numEntries; i++ {
+		entry := entries[i*entrySize : i*entrySize+entrySize]
+		typeGUID := entry[0:16]
+		switch {
+		case bytes.Equal(typeGUID, gptMicrosoftBasicData):
+			return Windows, nil
+		case bytes.Equal(typeGUID, gptLinuxFilesystem):
+			sawLinux = true
+		}
+	}
+
+	if sawLinux {
+		return Linux, nil
+	}
+	return Unknown, nil
+}
@@ -0,0 +1,108 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDisk writes buf to a temp file and returns its path.
+func writeDisk(t *testing.T, buf []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(path, buf, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestDetectFamilyBareExtFilesystem(t *testing.T) {
+	buf := make([]byte, 4096)
+	copy(buf[extSuperblockMagicOffset:], extSuperblockMagic)
+	// No 0x55AA boot signature: this is a bare filesystem, not a partitioned disk.
+
+	family, err := detectFamilyAtPath(writeDisk(t, buf))
+	if err != nil {
+		t.Fatalf("detectFamilyAtPath: %v", err)
+	}
+	if family != Linux {
+		t.Errorf("got %v, want %v", family, Linux)
+	}
+}
+
+func TestDetectFamilyBareNTFSFilesystem(t *testing.T) {
+	buf := make([]byte, 512)
+	copy(buf[3:], ntfsBootMagic)
+
+	family, err := detectFamilyAtPath(writeDisk(t, buf))
+	if err != nil {
+		t.Fatalf("detectFamilyAtPath: %v", err)
+	}
+	if family != Windows {
+		t.Errorf("got %v, want %v", family, Windows)
+	}
+}
+
+func TestDetectFamilyMBRLinux(t *testing.T) {
+	buf := make([]byte, 512)
+	entry := buf[mbrPartitionTableOffset:]
+	entry[4] = mbrTypeLinux
+	buf[mbrBootSignatureOffset] = 0x55
+	buf[mbrBootSignatureOffset+1] = 0xaa
+
+	family, err := detectFamilyAtPath(writeDisk(t, buf))
+	if err != nil {
+		t.Fatalf("detectFamilyAtPath: %v", err)
+	}
+	if family != Linux {
+		t.Errorf("got %v, want %v", family, Linux)
+	}
+}
+
+// TestDetectFamilyGPTDoesNotMisreadPartitionNameAsExtSuperblock builds a GPT
+// disk whose first partition entry's name field happens to contain the ext
+// superblock magic at absolute offset 1080 (entries start at byte 1024, the
+// name field sits at entry-offset +56). detectFamilyAtPath must still walk
+// the GPT table rather than short-circuiting on that coincidental byte
+// match.
+func TestDetectFamilyGPTDoesNotMisreadPartitionNameAsExtSuperblock(t *testing.T) {
+	const sectorSize = 512
+	buf := make([]byte, 4*sectorSize)
+
+	sector0 := buf[0:sectorSize]
+	sector0[mbrPartitionTableOffset+4] = gptProtectiveType
+	sector0[mbrBootSignatureOffset] = 0x55
+	sector0[mbrBootSignatureOffset+1] = 0xaa
+
+	header := buf[sectorSize : 2*sectorSize]
+	putUint64(header[72:80], 2)   // partition entry array starts at LBA2
+	putUint32(header[80:84], 1)   // one entry
+	putUint32(header[84:88], 128) // 128 bytes per entry, the GPT spec minimum
+
+	entries := buf[2*sectorSize : 3*sectorSize]
+	copy(entries[0:16], gptMicrosoftBasicData)
+	// Entry 0 starts at absolute byte 1024; +56 is byte 1080, where the bare
+	// ext-superblock check used to look unconditionally.
+	copy(entries[56:58], extSuperblockMagic)
+
+	family, err := detectFamilyAtPath(writeDisk(t, buf))
+	if err != nil {
+		t.Fatalf("detectFamilyAtPath: %v", err)
+	}
+	if family != Windows {
+		t.Errorf("got %v, want %v (GPT partition type should win over the coincidental ext magic bytes)", family, Windows)
+	}
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+	b[3] = byte(v >> 24)
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := 0; i < 8; i++ {
+		b[i] = byte(v >> (8 * i))
+	}
+}
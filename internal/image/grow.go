@@ -0,0 +1,63 @@
+package image
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// DefaultGrowSlackPercent is the slack added on top of the computed
+// required size when --auto-grow is given without an explicit percentage.
+const DefaultGrowSlackPercent = 10
+
+// GrowResult reports how a destination image was enlarged to make room
+// for the source data.
+type GrowResult struct {
+	FromMB int
+	ToMB   int
+}
+
+// RequiredSizeMB estimates the size a destination image needs to hold the
+// partition table found on src, plus slackPercent extra room.
+//
+// This currently sums the sizes of the partitions on src as reported by
+// lsblk; accounting for the actual filesystem bytes used per partition
+// will follow once the copy stage that walks each filesystem lands.
+func RequiredSizeMB(src string, slackPercent int) (int, error) {
+	out, err := exec.Command("lsblk", "--bytes", "--noheadings", "--nodeps", "--output", "SIZE", src).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	var totalBytes int64
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			continue
+		}
+		totalBytes += n
+	}
+
+	requiredMB := int(totalBytes / 1024 / 1024)
+	return requiredMB + requiredMB*slackPercent/100, nil
+}
+
+// EnsureCapacity grows dst so it can hold requiredMB, if it isn't already
+// big enough. It returns nil if no grow was necessary.
+func (img *Image) EnsureCapacity(requiredMB int) (*GrowResult, error) {
+	current, err := img.VirtualSize()
+	if err != nil {
+		return nil, err
+	}
+	if current >= requiredMB {
+		return nil, nil
+	}
+	if err := img.Resize(requiredMB); err != nil {
+		return nil, err
+	}
+	return &GrowResult{FromMB: current, ToMB: requiredMB}, nil
+}
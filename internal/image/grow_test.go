@@ -0,0 +1,56 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// stubLsblk puts a fake "lsblk" on PATH that prints sizeBytes once per line,
+// standing in for `lsblk --bytes --noheadings --nodeps --output SIZE <src>`.
+func stubLsblk(t *testing.T, sizeBytes ...int64) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("stubLsblk relies on a POSIX shell script")
+	}
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\n"
+	for _, n := range sizeBytes {
+		script += "echo " + strconv.FormatInt(n, 10) + "\n"
+	}
+	path := filepath.Join(dir, "lsblk")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRequiredSizeMB(t *testing.T) {
+	const oneMB = 1024 * 1024
+	stubLsblk(t, 100*oneMB)
+
+	got, err := RequiredSizeMB("/dev/fake", 10)
+	if err != nil {
+		t.Fatalf("RequiredSizeMB: %v", err)
+	}
+	if want := 110; got != want {
+		t.Errorf("RequiredSizeMB = %d, want %d", got, want)
+	}
+}
+
+func TestRequiredSizeMBNoSlack(t *testing.T) {
+	const oneMB = 1024 * 1024
+	stubLsblk(t, 50*oneMB)
+
+	got, err := RequiredSizeMB("/dev/fake", 0)
+	if err != nil {
+		t.Fatalf("RequiredSizeMB: %v", err)
+	}
+	if want := 50; got != want {
+		t.Errorf("RequiredSizeMB = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,242 @@
+// Package image parses the --source-image/--destination-image command line
+// syntax and owns attaching the resulting virtual image to a block device,
+// regardless of its on-disk container format. Callers only ever see a
+// device path; the Backend implementations in this package deal with loop
+// devices, qemu-nbd and libguestfs.
+package image
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Jeansen/bcrm_go/internal/i18n"
+)
+
+// Type identifies the on-disk container format of a virtual image.
+type Type string
+
+const (
+	Raw   Type = "raw"
+	Vdi   Type = "vdi"
+	Qcow2 Type = "qcow2"
+	Vmdk  Type = "vmdk"
+	Vhdx  Type = "vhdx"
+)
+
+var validTypes = map[Type]bool{
+	Raw:   true,
+	Vdi:   true,
+	Qcow2: true,
+	Vmdk:  true,
+	Vhdx:  true,
+}
+
+// Family describes the guest layout found inside an image. It decides
+// whether the Linux native tool chain or the ntfs-3g tool chain is used to
+// read and write the partitions of the attached image.
+type Family string
+
+const (
+	Linux   Family = "linux"
+	Windows Family = "windows"
+	Unknown Family = "unknown"
+)
+
+// Image describes a source or destination image as given on the command
+// line, along with the backend currently attaching it, if any.
+type Image struct {
+	Path      string
+	Type      Type
+	CanonSize string
+	SizeMB    int
+
+	backend Backend
+	device  string
+}
+
+// Parse parses the "<path>:<type>[:<size>]" syntax accepted by
+// --source-image and --destination-image.
+func Parse(s string) (Image, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Image{}, errors.New(i18n.Trf("Invalid image spec, expected <path>:<type>[:<size>]: %s", s))
+	}
+
+	img := Image{
+		Path: parts[0],
+		Type: Type(parts[1]),
+	}
+
+	if !validTypes[img.Type] {
+		return Image{}, errors.New(i18n.Trf("Unsupported image type %q, must be one of raw|vdi|qcow2|vmdk|vhdx", parts[1]))
+	}
+
+	if len(parts) == 3 {
+		sizeMB, err := ParseSize(parts[2])
+		if err != nil {
+			return Image{}, err
+		}
+		img.CanonSize = parts[2]
+		img.SizeMB = sizeMB
+	}
+
+	return img, nil
+}
+
+// ParseSize converts a size string postfixed with K, M, G or T (see the
+// usage text) into megabytes.
+func ParseSize(s string) (int, error) {
+	if len(s) < 2 {
+		return 0, errors.New(i18n.Trf("Invalid size value: %s", s))
+	}
+
+	unit := s[len(s)-1]
+	value, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return 0, errors.New(i18n.Trf("Invalid size value: %s", s))
+	}
+
+	switch unit {
+	case 'K':
+		return value / 1024, nil
+	case 'M':
+		return value, nil
+	case 'G':
+		return value * 1024, nil
+	case 'T':
+		return value * 1024 * 1024, nil
+	default:
+		return 0, errors.New(i18n.Trf("Invalid size indicator, must be one of K|M|G|T: %s", s))
+	}
+}
+
+// familyDetector is implemented by backends that can report a guest's
+// family without handing back a real block device for detectFamilyAtPath
+// to read sectors from (currently just libguestfsBackend, whose Attach
+// only ever produces a FUSE mountpoint).
+type familyDetector interface {
+	DetectFamily() (Family, error)
+}
+
+// DetectFamily sniffs the guest layout of img so the caller can pick the
+// right partition and boot tool chain (ntfs-3g for Windows, the native
+// Linux tools otherwise). It understands MBR- and GPT-partitioned disks as
+// well as a bare NTFS/ext filesystem with no partition table.
+//
+// raw images are inspected directly. Every other container format
+// (qcow2/vmdk/vhdx/vdi) is briefly attached through a Backend to read its
+// partition table, then detached again; img itself is left untouched, so
+// this is safe to call ahead of a real Attach(). The libguestfs backend
+// never exposes a block device to read sectors from, so it is asked to
+// detect its own family instead of going through Attach() at all.
+func (img Image) DetectFamily() (Family, error) {
+	if img.Type == Raw {
+		return detectFamilyAtPath(img.Path)
+	}
+
+	b, err := NewBackend(img.Type)
+	if err != nil {
+		return Unknown, err
+	}
+	if err := b.Open(img.Path); err != nil {
+		return Unknown, err
+	}
+
+	if fd, ok := b.(familyDetector); ok {
+		return fd.DetectFamily()
+	}
+
+	device, err := b.Attach()
+	if err != nil {
+		return Unknown, err
+	}
+	defer b.Detach()
+
+	return detectFamilyAtPath(device)
+}
+
+// RequireWindowsTools makes sure the external tool chain needed to clone
+// NTFS/ReFS partitions is installed on the host.
+func RequireWindowsTools() error {
+	for _, tool := range []string{"ntfs-3g", "ntfsclone", "ntfsresize"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return errors.New(i18n.Trf("Windows image support requires %s to be installed", tool))
+		}
+	}
+	return nil
+}
+
+// Attach opens the image and attaches it to a block device using the best
+// backend available for its Type, and remembers the backend for Detach.
+func (img *Image) Attach() (device string, err error) {
+	b, err := NewBackend(img.Type)
+	if err != nil {
+		return "", err
+	}
+	if err := b.Open(img.Path); err != nil {
+		return "", err
+	}
+	device, err = b.Attach()
+	if err != nil {
+		return "", err
+	}
+	img.backend = b
+	img.device = device
+	return device, nil
+}
+
+// Device returns the block device the image was last attached to, or "" if
+// Attach has not been called yet.
+func (img Image) Device() string {
+	return img.device
+}
+
+// Detach releases whatever resources Attach acquired. It is a no-op if the
+// image was never attached.
+func (img *Image) Detach() error {
+	if img.backend == nil {
+		return nil
+	}
+	err := img.backend.Detach()
+	img.backend = nil
+	img.device = ""
+	return err
+}
+
+// Resize grows or shrinks the virtual image to sizeMB. Unlike Attach/Detach
+// this does not require the image to be attached to a device: resizing the
+// container file is independent of whatever currently has it open.
+func (img *Image) Resize(sizeMB int) error {
+	b, err := img.openBackend()
+	if err != nil {
+		return err
+	}
+	return b.Resize(sizeMB)
+}
+
+// VirtualSize returns the declared virtual size of the image in megabytes.
+func (img *Image) VirtualSize() (int, error) {
+	b, err := img.openBackend()
+	if err != nil {
+		return 0, err
+	}
+	return b.VirtualSize()
+}
+
+// openBackend returns the backend attached to img, opening a fresh one
+// against img.Path if Attach has not been called yet.
+func (img *Image) openBackend() (Backend, error) {
+	if img.backend != nil {
+		return img.backend, nil
+	}
+	b, err := NewBackend(img.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Open(img.Path); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
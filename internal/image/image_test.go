@@ -0,0 +1,56 @@
+package image
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"512K", 0, false},
+		{"2048K", 2, false},
+		{"100M", 100, false},
+		{"2G", 2048, false},
+		{"1T", 1024 * 1024, false},
+		{"", 0, true},
+		{"5", 0, true},
+		{"5X", 0, true},
+		{"abcM", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseSize(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q): expected error, got %d", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSize(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	img, err := Parse("/tmp/disk.img:raw:10G")
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if img.Path != "/tmp/disk.img" || img.Type != Raw || img.CanonSize != "10G" || img.SizeMB != 10*1024 {
+		t.Errorf("Parse: got %+v", img)
+	}
+
+	if _, err := Parse("/tmp/disk.img:raw:10G:extra"); err == nil {
+		t.Error("Parse: expected error for too many ':'-separated parts")
+	}
+
+	if _, err := Parse("/tmp/disk.img:bogus"); err == nil {
+		t.Error("Parse: expected error for unsupported image type")
+	}
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// rawBackend attaches a plain raw disk image via a loop device.
+type rawBackend struct {
+	path   string
+	device string
+}
+
+func (b *rawBackend) Open(path string) error {
+	b.path = path
+	return nil
+}
+
+func (b *rawBackend) Attach() (string, error) {
+	out, err := exec.Command("losetup", "--show", "-f", "-P", b.path).Output()
+	if err != nil {
+		return "", err
+	}
+	b.device = strings.TrimSpace(string(out))
+	return b.device, nil
+}
+
+func (b *rawBackend) Detach() error {
+	if b.device == "" {
+		return nil
+	}
+	err := exec.Command("losetup", "-d", b.device).Run()
+	b.device = ""
+	return err
+}
+
+func (b *rawBackend) Resize(sizeMB int) error {
+	f, err := os.OpenFile(b.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(int64(sizeMB) * 1024 * 1024)
+}
+
+func (b *rawBackend) VirtualSize() (int, error) {
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return 0, err
+	}
+	return int(fi.Size() / 1024 / 1024), nil
+}
@@ -8,125 +8,161 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"golang.org/x/sys/unix"
 
 	"github.com/pborman/getopt/v2"
+
+	"github.com/Jeansen/bcrm_go/internal/image"
 )
 
 type arguments struct {
-	Src              *string
-	Dest             *string
-	Uefi             *bool
-	Help             *bool
-	destImg          *[]string
-	srctImg          *[]string
-	DestImg          bcrmImg
-	SrctImg          bcrmImg
-	NewVgName        *string
-	EncryptPw        *string
-	Hostname         *string
-	MakeUefi         *bool
-	UseAllPvs        *bool
-	Quiet            *bool
-	Split            *bool
-	Check            *bool
-	Compress         *bool
-	ResizeThreshold  *string
-	SwapSize         *string
-	BootSize         *string
-	LvmExpand        *string
-	VgFreeSize       *string
-	RemovePkgs       *[]string
-	Schroot          *bool
-	DisableMount     *string
-	NoCleanup        *bool
-	AllToLvm         *bool
-	IncludePartition *[]string
-	ToLvm            *string
-}
-
-type bcrmImg struct {
-	Path      string
-	Type      string
-	CanonSize string
-	SizeMB    int
+	Src                *string
+	Dest               *string
+	Uefi               *bool
+	Help               *bool
+	destImg            *[]string
+	srctImg            *[]string
+	DestImg            image.Image
+	SrctImg            image.Image
+	autoGrowRequiredMB int
+	ConvertImageTo     *string
+	AutoGrow           *string
+	Completion         *string
+	DryRun             *bool
+	PlanFormat         *string
+	BwLimit            *string
+	Xattrs             *bool
+	Acls               *bool
+	RsyncArgs          *[]string
+	NewVgName          *string
+	EncryptPw          *string
+	Hostname           *string
+	MakeUefi           *bool
+	UseAllPvs          *bool
+	Quiet              *bool
+	Split              *bool
+	Check              *bool
+	Compress           *bool
+	ResizeThreshold    *string
+	SwapSize           *string
+	BootSize           *string
+	LvmExpand          *string
+	VgFreeSize         *string
+	RemovePkgs         *[]string
+	Schroot            *bool
+	DisableMount       *string
+	NoCleanup          *bool
+	AllToLvm           *bool
+	IncludePartition   *[]string
+	ToLvm              *string
 }
 
 var hidden = regexp.MustCompile(`^\..*`)
 
-var usage = `
-    Usage: $(basename $0) -s <source> -d <destination> [options]
-
-    OPTIONS
-    -------
-    -s, --source                 The source device or folder to clone or restore from 
-    -d, --destination            The destination device or folder to clone or backup to 
-        --source-image           Use the given image as source in the form of <path>:<type> 
-                                 For example: '/path/to/file.vdi:vdi'. See below for supported types. 
-        --destination-image      Use the given image as destination in the form of <path>:<type>[:<virtual-size>] 
-                                 For instance: '/path/to/file.img:raw:20G' 
-                                 If you omit the size, the image file must exists. 
-                                 If you provide a size, the image file will be created or overwritten. 
-    -c, --check                  Create/Validate checksums 
-    -z, --compress               Use compression (compression ratio is about 1:3, but very slow!) 
-        --split                  Split backup into chunks of 1G files 
-    -H, --hostname               Set hostname 
-        --remove-pkgs            Remove the given list of whitespace-separated packages as a final step. 
-                                 The whole list must be enclosed in ""
-    -n, --new-vg-name            LVM only: Define new volume group name 
-        --vg-free-size           LVM only: How much space should be added to remaining free space in source VG. 
-    -e, --encrypt-with-password  LVM only: Create encrypted disk with supplied passphrase 
-    -p, --use-all-pvs            LVM only: Use all disks found on destination as PVs for VG 
-        --lvm-expand             LVM only: Have the given LV use the remaining free space. 
-                                 An optional percentage can be supplied, e.g. 'root:80' 
-                                 Which would add 80% of the remaining free space in a VG to this LV 
-    -u, --make-uefi              Convert to UEFI 
-    -w, --swap-size              Swap partition size. May be zero to remove any swap partition. 
-    -m, --resize-threshold       Do not resize partitions smaller than <size> (default 2048M) 
-        --schroot                Run in a secure chroot environment with a fixed and tested tool chain 
-        --no-cleanup             Do not remove temporary (backup) files and mounts. 
-                                 Useful when tracking down errors with --schroot. 
-        --disable-mount          Disable the given mount point in <destination>/etc/fstab. 
-                                 For instance --disable-mount /some/path. Can be used multiple times. 
-        --to-lvm                 Convert given source partition to LV. E.g. '/dev/sda1:boot' would be 
-                                 converted to LV with the name 'boot' Can be used multiple times. 
-                                 Only works for partitions that have a valid mountpoint in fstab 
-        --all-to-lvm             Convert all source partitions to LV. (except EFI) 
-        --include-partition      Also include the content of the given partition to the specified path. 
-                                 E.g: 'part=/dev/sdX,dir=/some/path/,user=1000,group=10001,exclude=fodler1,folder2' 
-                                 would copy all content from /dev/sdX to /some/path. 
-                                 If /some/path does not exist, it will be created with the given user 
-                                 and group ID, or root otherwise. With exclude you can filter folders and files. 
-                                 This option can be specified multiple times. 
-    -q, --quiet                  Quiet, do not show any output 
-    -h, --help                   Show this help text 
-
-   
-    ADVANCED OPTIONS
-    ----------------
-    -b, --boot-size               Boot partition size. For instance: 200M or 4G. 
-                                  Be careful, the  script only checks for the bootable flag, 
-                                  Only use with a dedicated /boot partition 
-
-    ADDITIONAL NOTES
-    ----------------
-    Size values must be postfixed with a size indcator, e.g: 200M or 4G. The following indicators are valid:
-
-    K [kilobytes]
-    M [megabytes]
-    G [gigabytes]
-    T [terabytes]
-
-    When using virtual images you always have to provide the image type. Currently the following image types are supported:
-
-    raw    Plain binary 
-    vdi    Virtual Box 
-    qcow2  QEMU/KVM 
-    vmdk   VMware 
-    vhdx   Hyper-V   
-`
+// usageLines builds the help text as one tr()-wrapped literal per line,
+// instead of one big template string, so the "extract" target in
+// po/Makefile (which runs xgettext over literal tr(...) arguments) can
+// actually pull every line into the translation catalog. It is a function,
+// not a package-level var: Go runs var initializers before any init() func,
+// and it's an init() in i18n.go that binds the locale gettext.Gettext
+// reads from, so resolving these tr() calls at var-init time would always
+// run before a catalog was loaded.
+func usageLines() []string {
+	return []string{
+		"",
+		tr("    Usage: $(basename $0) -s <source> -d <destination> [options]"),
+		"",
+		tr("    OPTIONS"),
+		tr("    -------"),
+		tr("    -s, --source                 The source device or folder to clone or restore from "),
+		tr("    -d, --destination            The destination device or folder to clone or backup to "),
+		tr("        --source-image           Use the given image as source in the form of <path>:<type> "),
+		tr("                                 For example: '/path/to/file.vdi:vdi'. See below for supported types. "),
+		tr("        --destination-image      Use the given image as destination in the form of <path>:<type>[:<virtual-size>] "),
+		tr("                                 For instance: '/path/to/file.img:raw:20G' "),
+		tr("                                 If you omit the size, the image file must exists."),
+		tr("                                 If you provide a size, the image file will be created or overwritten."),
+		tr("        --convert-image-to       Convert --source-image to the given type and write it to --destination-image,"),
+		tr("                                 instead of cloning. For instance 'qcow2' to convert a raw image to qcow2."),
+		tr("        --auto-grow[=<percent>]  Grow --destination-image if the source data does not fit, instead of failing"),
+		tr("                                 partway through. Adds <percent> extra slack on top of the computed required"),
+		tr("                                 size, or 10% if no percentage is given."),
+		tr("        --dry-run                Do not clone anything, print the cloning plan instead. See --plan-format."),
+		tr("        --plan-format             Format of the --dry-run plan, one of 'json' or 'yaml' (default 'json')."),
+		tr("        --bwlimit                 Directory mode only: Limit rsync's bandwidth, e.g. '2m' for 2 MB/s."),
+		tr("        --xattrs                  Directory mode only: Preserve extended attributes."),
+		tr("        --acls                    Directory mode only: Preserve POSIX ACLs."),
+		tr("        --rsync-arg               Directory mode only: Forward the given extra rsync flag, e.g. '--sparse'."),
+		tr("                                 Must be one of the flags rsync --help exposes that bcrm allows through."),
+		tr("                                 Can be used multiple times."),
+		tr("    -c, --check                  Create/Validate checksums"),
+		tr("    -z, --compress               Use compression (compression ratio is about 1:3, but very slow!) "),
+		tr("        --split                  Split backup into chunks of 1G files "),
+		tr("    -H, --hostname               Set hostname "),
+		tr("        --remove-pkgs            Remove the given list of whitespace-separated packages as a final step. "),
+		tr("                                 The whole list must be enclosed in \"\""),
+		tr("    -n, --new-vg-name            LVM only: Define new volume group name "),
+		tr("        --vg-free-size           LVM only: How much space should be added to remaining free space in source VG. "),
+		tr("    -e, --encrypt-with-password  LVM only: Create encrypted disk with supplied passphrase "),
+		tr("    -p, --use-all-pvs            LVM only: Use all disks found on destination as PVs for VG "),
+		tr("        --lvm-expand             LVM only: Have the given LV use the remaining free space. "),
+		tr("                                 An optional percentage can be supplied, e.g. 'root:80' "),
+		tr("                                 Which would add 80% of the remaining free space in a VG to this LV "),
+		tr("    -u, --make-uefi              Convert to UEFI "),
+		tr("    -w, --swap-size              Swap partition size. May be zero to remove any swap partition. "),
+		tr("    -m, --resize-threshold       Do not resize partitions smaller than <size> (default 2048M) "),
+		tr("        --schroot                Run in a secure chroot environment with a fixed and tested tool chain "),
+		tr("        --no-cleanup             Do not remove temporary (backup) files and mounts. "),
+		tr("                                 Useful when tracking down errors with --schroot. "),
+		tr("        --disable-mount          Disable the given mount point in <destination>/etc/fstab. "),
+		tr("                                 For instance --disable-mount /some/path. Can be used multiple times. "),
+		tr("        --to-lvm                 Convert given source partition to LV. E.g. '/dev/sda1:boot' would be "),
+		tr("                                 converted to LV with the name 'boot' Can be used multiple times. "),
+		tr("                                 Only works for partitions that have a valid mountpoint in fstab "),
+		tr("        --all-to-lvm             Convert all source partitions to LV. (except EFI) "),
+		tr("        --include-partition      Also include the content of the given partition to the specified path. "),
+		tr("                                 E.g: 'part=/dev/sdX,dir=/some/path/,user=1000,group=10001,exclude=fodler1,folder2' "),
+		tr("                                 would copy all content from /dev/sdX to /some/path. "),
+		tr("                                 If /some/path does not exist, it will be created with the given user "),
+		tr("                                 and group ID, or root otherwise. With exclude you can filter folders and files. "),
+		tr("                                 This option can be specified multiple times. "),
+		tr("    -q, --quiet                  Quiet, do not show any output "),
+		tr("    -h, --help                   Show this help text "),
+		"",
+		"   ",
+		tr("    ADVANCED OPTIONS"),
+		tr("    ----------------"),
+		tr("    -b, --boot-size               Boot partition size. For instance: 200M or 4G. "),
+		tr("                                  Be careful, the  script only checks for the bootable flag, "),
+		tr("                                  Only use with a dedicated /boot partition "),
+		"",
+		tr("    ADDITIONAL NOTES"),
+		tr("    ----------------"),
+		tr("    Size values must be postfixed with a size indcator, e.g: 200M or 4G. The following indicators are valid:"),
+		"",
+		tr("    K [kilobytes]"),
+		tr("    M [megabytes]"),
+		tr("    G [gigabytes]"),
+		tr("    T [terabytes]"),
+		"",
+		tr("    When using virtual images you always have to provide the image type. Currently the following image types are supported:"),
+		"",
+		tr("    raw    Plain binary"),
+		tr("    vdi    Virtual Box"),
+		tr("    qcow2  QEMU/KVM"),
+		tr("    vmdk   VMware"),
+		tr("    vhdx   Hyper-V"),
+		"",
+		tr("    Windows disks (NTFS/ReFS, raw or virtual image) are supported as well. The guest"),
+		tr("    layout is auto-detected; cloning a Windows image requires ntfs-3g, ntfsclone and"),
+		tr("    ntfsresize to be installed on the host."),
+		"",
+	}
+}
+
 var args = arguments{}
 
 func init() {
@@ -134,7 +170,7 @@ func init() {
 	getopt.DisplayWidth = 70
 
 	help := func() {
-		fmt.Println(usage)
+		fmt.Println(strings.Join(usageLines(), "\n"))
 	}
 
 	getopt.SetUsage(help)
@@ -144,6 +180,19 @@ func init() {
 	args.srctImg = getopt.ListLong("source-image", 'S')
 	args.destImg = getopt.ListLong("destination-image", 'D')
 	args.Dest = getopt.StringLong("destination", 'd', "")
+	args.ConvertImageTo = getopt.StringLong("convert-image-to", 0, "")
+	args.AutoGrow = getopt.StringLong("auto-grow", 0, "")
+	getopt.Lookup("auto-grow").SetOptional()
+	// --completion is intentionally left out of the usage text; it is only
+	// meant to be invoked by the shells themselves, via "eval $(bcrm
+	// completion bash)" or equivalent.
+	args.Completion = getopt.StringLong("completion", 0, "")
+	args.DryRun = getopt.BoolLong("dry-run", 0)
+	args.PlanFormat = getopt.StringLong("plan-format", 0, "json")
+	args.BwLimit = getopt.StringLong("bwlimit", 0, "")
+	args.Xattrs = getopt.BoolLong("xattrs", 0)
+	args.Acls = getopt.BoolLong("acls", 0)
+	args.RsyncArgs = getopt.ListLong("rsync-arg", 0)
 	args.NewVgName = getopt.StringLong("new-vg-name", 'n', "")
 	args.EncryptPw = getopt.StringLong("encrypt-with-password", 'e', "")
 	args.Hostname = getopt.StringLong("hostname", 'H', "")
@@ -183,8 +232,65 @@ func init() {
 }
 
 func main() {
+	if os.Getenv("BCRM_COMPLETE") == "1" && len(os.Args) >= 3 {
+		runDynamicCompletion(os.Args[1:])
+		return
+	}
+	if runCompletionSubcommand(os.Args[1:]) {
+		return
+	}
+
 	getopt.Parse()
-	fmt.Println(args.validate(getopt.CommandLine))
+	if *args.Completion != "" {
+		script, err := generateCompletionScript(completionShell(*args.Completion), getopt.CommandLine)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(script)
+		return
+	}
+
+	if err := args.validate(getopt.CommandLine); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if *args.DryRun {
+		plan, err := args.buildPlan()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		out, err := plan.render(*args.PlanFormat)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(out)
+		return
+	}
+
+	if err := args.applyAutoGrow(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if *args.ConvertImageTo != "" {
+		if err := image.Convert(args.SrctImg, args.DestImg.Path, image.Type(*args.ConvertImageTo)); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	srcm, _ := os.Stat(*args.Src)
+	destm, _ := os.Stat(*args.Dest)
+	if srcm.Mode()&os.ModeDir != 0 || destm.Mode()&os.ModeDir != 0 {
+		if err := args.runRsync(*args.Src, *args.Dest); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
 }
 
 func isAccessable(fi os.FileInfo) bool {
@@ -234,14 +340,14 @@ func isEmptyDir(file string) bool {
 
 func validatePath(file string) error {
 	if len(file) == 0 {
-		return errors.New("Parameter value is empty.")
+		return errors.New(tr("Parameter value is empty."))
 	}
 	if srcm, ok := os.Stat(file); ok == nil {
 		if mode := srcm.Mode(); mode&os.ModeDevice == 0 && mode&os.ModeDir == 0 {
-			return errors.New("Invalid Folder or device.")
+			return errors.New(tr("Invalid Folder or device."))
 		}
 	} else {
-		return errors.New("Folder or device does not exist.")
+		return errors.New(tr("Folder or device does not exist."))
 	}
 	return nil
 }
@@ -253,16 +359,16 @@ func (args *arguments) validate(params *getopt.Set) error {
 			os.Exit(0)
 		}
 		if !params.IsSet("source") {
-			return errors.New("Missing required option -s <source>")
+			return errors.New(tr("Missing required option -s <source>"))
 		}
 		if err := validatePath(*args.Src); err != nil {
-			return errors.New("-s: " + error.Error(err))
+			return errors.New(trf("-s: %s", err))
 		}
 		if !params.IsSet("destination") {
-			return errors.New("Missing required option -d <destinaton>")
+			return errors.New(tr("Missing required option -d <destinaton>"))
 		}
 		if err := validatePath(*args.Dest); err != nil {
-			return errors.New("-s: " + error.Error(err))
+			return errors.New(trf("-d: %s", err))
 		}
 		return nil
 	}
@@ -275,33 +381,122 @@ func (args *arguments) validate(params *getopt.Set) error {
 		dmode := destm.Mode()
 
 		if os.SameFile(srcm, destm) {
-			return errors.New("Source and destination cannot be the same!")
+			return errors.New(tr("Source and destination cannot be the same!"))
 		}
 		if smode&os.ModeDir != 0 && dmode&os.ModeDevice != 0 && isEmptyDir(*args.Src) {
-			return errors.New("No backup available. Source is empty!")
+			return errors.New(tr("No backup available. Source is empty!"))
 		}
 		if smode&os.ModeDevice != 0 && dmode&os.ModeDir != 0 && !isEmptyDir(*args.Dest) {
-			return errors.New("Destination not empty!")
+			return errors.New(tr("Destination not empty!"))
 		}
 		if smode&os.ModeDir != 0 && dmode&os.ModeDevice == 0 {
-			return errors.New(*args.Dest + " is not a valid block device")
+			return errors.New(trf("%s is not a valid block device", *args.Dest))
 		}
 		if smode&os.ModeDevice == 0 && dmode&os.ModeDir != 0 {
-			return errors.New(*args.Src + " is not a valid block device")
+			return errors.New(trf("%s is not a valid block device", *args.Src))
 		}
 		if smode&os.ModeDevice == 0 && smode&os.ModeDir == 0 && dmode&os.ModeDir != 0 {
-			return errors.New("Invalid device or directory: " + *args.Src)
+			return errors.New(trf("Invalid device or directory: %s", *args.Src))
 		}
 		if smode&os.ModeDir != 0 && dmode&os.ModeDevice == 0 && dmode&os.ModeDir == 0 {
-			return errors.New("Invalid device or directory: " + *args.Dest)
+			return errors.New(trf("Invalid device or directory: %s", *args.Dest))
 		}
 
 		if smode&os.ModeDir != 0 && !isReadable(srcm) {
-			return errors.New(*args.Src + " is not readable")
+			return errors.New(trf("%s is not readable", *args.Src))
 		}
 		if dmode&os.ModeDir != 0 && !isAccessable(destm) {
-			return errors.New(*args.Dest + " is not writable")
+			return errors.New(trf("%s is not writable", *args.Dest))
+		}
+		return nil
+	}
+
+	_checkImages := func() error {
+		if len(*args.srctImg) > 0 {
+			img, err := image.Parse((*args.srctImg)[0])
+			if err != nil {
+				return err
+			}
+			args.SrctImg = img
+		}
+		if len(*args.destImg) > 0 {
+			img, err := image.Parse((*args.destImg)[0])
+			if err != nil {
+				return err
+			}
+			args.DestImg = img
+		}
+
+		for _, img := range []image.Image{args.SrctImg, args.DestImg} {
+			if img.Path == "" {
+				continue
+			}
+			// An image with a declared size but no file on disk yet is
+			// about to be created (or overwritten) rather than read, per
+			// --destination-image's own usage text; there is nothing to
+			// sniff a family from.
+			if img.CanonSize != "" {
+				if _, err := os.Stat(img.Path); os.IsNotExist(err) {
+					continue
+				}
+			}
+			family, err := img.DetectFamily()
+			if err != nil {
+				return err
+			}
+			if family == image.Windows {
+				if err := image.RequireWindowsTools(); err != nil {
+					return err
+				}
+			}
 		}
+
+		if *args.ConvertImageTo != "" {
+			if args.SrctImg.Path == "" {
+				return errors.New(tr("--convert-image-to requires --source-image"))
+			}
+			if args.DestImg.Path == "" {
+				return errors.New(tr("--convert-image-to requires --destination-image"))
+			}
+		}
+		return nil
+	}
+
+	_checkRsyncArgs := func() error {
+		for _, a := range *args.RsyncArgs {
+			if err := validateRsyncArg(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// _checkAutoGrow only validates --auto-grow and computes the required
+	// destination size; it must not touch the destination image itself, since
+	// validate() also runs ahead of --dry-run. The actual grow happens in
+	// applyAutoGrow, once we know we're not in a dry run.
+	_checkAutoGrow := func() error {
+		if !params.IsSet("auto-grow") {
+			return nil
+		}
+		if args.DestImg.Path == "" {
+			return errors.New(tr("--auto-grow requires --destination-image"))
+		}
+
+		percent := image.DefaultGrowSlackPercent
+		if *args.AutoGrow != "" {
+			p, err := strconv.Atoi(*args.AutoGrow)
+			if err != nil {
+				return errors.New(trf("Invalid --auto-grow percentage: %s", *args.AutoGrow))
+			}
+			percent = p
+		}
+
+		required, err := image.RequiredSizeMB(*args.Src, percent)
+		if err != nil {
+			return err
+		}
+		args.autoGrowRequiredMB = required
 		return nil
 	}
 
@@ -311,6 +506,34 @@ func (args *arguments) validate(params *getopt.Set) error {
 	if err := _check(); err != nil {
 		return err
 	}
+	if err := _checkImages(); err != nil {
+		return err
+	}
+	if err := _checkRsyncArgs(); err != nil {
+		return err
+	}
+	if err := _checkAutoGrow(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// applyAutoGrow grows DestImg to fit the source data if --auto-grow was
+// given and validate found the image too small for it. This is the one step
+// of auto-grow handling that actually mutates the destination image, so
+// main only calls it once a real (non-dry-run) clone is about to happen.
+func (args *arguments) applyAutoGrow() error {
+	if args.autoGrowRequiredMB == 0 {
+		return nil
+	}
+
+	grown, err := args.DestImg.EnsureCapacity(args.autoGrowRequiredMB)
+	if err != nil {
+		return err
+	}
+	if grown != nil && !*args.Quiet {
+		fmt.Printf("Destination image grown from %dM to %dM to fit the source data\n", grown.FromMB, grown.ToMB)
+	}
 	return nil
 }
 
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Plan is the machine-readable description of what bcrm would do for the
+// current arguments, produced by --dry-run --plan-format=json|yaml instead
+// of actually cloning anything.
+type Plan struct {
+	Source           string    `json:"source" yaml:"source"`
+	Destination      string    `json:"destination" yaml:"destination"`
+	SourceImage      *Image    `json:"sourceImage,omitempty" yaml:"sourceImage,omitempty"`
+	DestinationImage *Image    `json:"destinationImage,omitempty" yaml:"destinationImage,omitempty"`
+	Lvm              *LvmPlan  `json:"lvm,omitempty" yaml:"lvm,omitempty"`
+	Grow             *GrowPlan `json:"grow,omitempty" yaml:"grow,omitempty"`
+	MakeUefi         bool      `json:"makeUefi" yaml:"makeUefi"`
+	RemovePackages   []string  `json:"removePackages,omitempty" yaml:"removePackages,omitempty"`
+}
+
+// Image is the plan's view of a --source-image/--destination-image entry.
+type Image struct {
+	Path string `json:"path" yaml:"path"`
+	Type string `json:"type" yaml:"type"`
+	Size string `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// LvmPlan describes the VG/LV changes implied by the LVM-related flags.
+type LvmPlan struct {
+	NewVgName  string `json:"newVgName,omitempty" yaml:"newVgName,omitempty"`
+	VgFreeSize string `json:"vgFreeSize,omitempty" yaml:"vgFreeSize,omitempty"`
+	LvmExpand  string `json:"lvmExpand,omitempty" yaml:"lvmExpand,omitempty"`
+	AllToLvm   bool   `json:"allToLvm" yaml:"allToLvm"`
+}
+
+// GrowPlan describes the --auto-grow resize a real run would perform,
+// without actually performing it.
+type GrowPlan struct {
+	FromMB int `json:"fromMB" yaml:"fromMB"`
+	ToMB   int `json:"toMB" yaml:"toMB"`
+}
+
+// buildPlan turns the parsed arguments into a Plan, without touching disk,
+// other than reading DestImg's current virtual size to report --auto-grow.
+func (args *arguments) buildPlan() (Plan, error) {
+	plan := Plan{
+		Source:      *args.Src,
+		Destination: *args.Dest,
+		MakeUefi:    *args.MakeUefi,
+	}
+
+	if args.SrctImg.Path != "" {
+		plan.SourceImage = &Image{Path: args.SrctImg.Path, Type: string(args.SrctImg.Type), Size: args.SrctImg.CanonSize}
+	}
+	if args.DestImg.Path != "" {
+		plan.DestinationImage = &Image{Path: args.DestImg.Path, Type: string(args.DestImg.Type), Size: args.DestImg.CanonSize}
+	}
+
+	if *args.NewVgName != "" || *args.VgFreeSize != "" || *args.LvmExpand != "" || *args.AllToLvm {
+		plan.Lvm = &LvmPlan{
+			NewVgName:  *args.NewVgName,
+			VgFreeSize: *args.VgFreeSize,
+			LvmExpand:  *args.LvmExpand,
+			AllToLvm:   *args.AllToLvm,
+		}
+	}
+
+	if args.autoGrowRequiredMB > 0 {
+		current, err := args.DestImg.VirtualSize()
+		if err != nil {
+			return Plan{}, err
+		}
+		if args.autoGrowRequiredMB > current {
+			plan.Grow = &GrowPlan{FromMB: current, ToMB: args.autoGrowRequiredMB}
+		}
+	}
+
+	if len(*args.RemovePkgs) > 0 {
+		plan.RemovePackages = strings.Fields(strings.Join(*args.RemovePkgs, " "))
+	}
+
+	return plan, nil
+}
+
+// render marshals the plan in the requested format ("json" or "yaml").
+func (plan Plan) render(format string) (string, error) {
+	switch format {
+	case "", "json":
+		b, err := json.MarshalIndent(plan, "", "  ")
+		return string(b), err
+	case "yaml":
+		b, err := yaml.Marshal(plan)
+		return string(b), err
+	default:
+		return "", errors.New(trf("Unsupported --plan-format %q, must be one of json|yaml", format))
+	}
+}
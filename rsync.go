@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// rsyncArgWhitelist are the extra rsync flags --rsync-arg is allowed to
+// forward verbatim, so an operator cannot smuggle arbitrary exec.Command
+// arguments (e.g. "--rsh=...") through bcrm.
+var rsyncArgWhitelist = map[string]bool{
+	"--sparse":         true,
+	"--inplace":        true,
+	"--delete":         true,
+	"--numeric-ids":    true,
+	"--info=progress2": true,
+	"--partial":        true,
+	"--whole-file":     true,
+	"--no-whole-file":  true,
+	"--compress":       true,
+	"--hard-links":     true,
+}
+
+// validateRsyncArg checks a single --rsync-arg value against the whitelist.
+func validateRsyncArg(arg string) error {
+	if rsyncArgWhitelist[arg] {
+		return nil
+	}
+	if strings.HasPrefix(arg, "--info=") || strings.HasPrefix(arg, "--partial-dir=") {
+		return nil
+	}
+	return errors.New(trf("--rsync-arg %q is not on the allowed list of extra rsync flags", arg))
+}
+
+// buildRsyncArgs assembles the rsync argument list used for directory-mode
+// backup/restore, honoring --bwlimit/--xattrs/--acls/--rsync-arg.
+func (args *arguments) buildRsyncArgs(src, dest string) ([]string, error) {
+	rsyncArgs := []string{"-a"}
+
+	if *args.BwLimit != "" {
+		rsyncArgs = append(rsyncArgs, "--bwlimit="+*args.BwLimit)
+	}
+	if *args.Xattrs {
+		rsyncArgs = append(rsyncArgs, "--xattrs")
+	}
+	if *args.Acls {
+		rsyncArgs = append(rsyncArgs, "--acls")
+	}
+	for _, a := range *args.RsyncArgs {
+		if err := validateRsyncArg(a); err != nil {
+			return nil, err
+		}
+		rsyncArgs = append(rsyncArgs, a)
+	}
+
+	return append(rsyncArgs, src, dest), nil
+}
+
+// runRsync execs rsync for a directory-mode backup/restore.
+func (args *arguments) runRsync(src, dest string) error {
+	rsyncArgs, err := args.buildRsyncArgs(src, dest)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("rsync", rsyncArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
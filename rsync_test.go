@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestValidateRsyncArg(t *testing.T) {
+	cases := []struct {
+		arg     string
+		wantErr bool
+	}{
+		{"--sparse", false},
+		{"--partial", false},
+		{"--partial-dir=/tmp/.rsync-partial", false},
+		{"--info=progress2", false},
+		{"--info=name1", false},
+		{"--partial-dir", true}, // needs a =DIR value; rsync would otherwise steal the next argv token
+		{"--rsh=/bin/sh -c whoami", true},
+		{"--exclude=*", true},
+	}
+
+	for _, c := range cases {
+		err := validateRsyncArg(c.arg)
+		if c.wantErr && err == nil {
+			t.Errorf("validateRsyncArg(%q): expected error, got nil", c.arg)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("validateRsyncArg(%q): unexpected error: %v", c.arg, err)
+		}
+	}
+}